@@ -8,8 +8,78 @@ type AHRS interface {
 	Update9D(gx, gy, gz, ax, ay, az, mx, my, mz float64) [4]float64
 	// Update6D updates position using 6D, returning quaternions
 	Update6D(gx, gy, gz, ax, ay, az float64) [4]float64
+	// Update9DDt updates position using 9D and an explicit timestep in seconds, returning quaternions
+	Update9DDt(gx, gy, gz, ax, ay, az, mx, my, mz, dt float64) [4]float64
+	// Update6DDt updates position using 6D and an explicit timestep in seconds, returning quaternions
+	Update6DDt(gx, gy, gz, ax, ay, az, dt float64) [4]float64
+	// PredictGyro advances the stored quaternion using only the gyroscope, returning quaternions
+	PredictGyro(gx, gy, gz, dt float64) [4]float64
 }
 
+// StandardGravity is the default reference accelerometer magnitude (in m/s^2)
+// used by the accelerometer rejection threshold.
+const StandardGravity = 9.80665
+
 func invSqrt(x float64) float64 {
 	return 1 / math.Sqrt(x)
 }
+
+// quaternionExpIntegrate advances quaternion q by the angular rate (gx, gy, gz)
+// over dt seconds using the closed-form axis-angle exponential map, rather
+// than the first-order Euler step used by Update6D/Update9D.
+func quaternionExpIntegrate(q [4]float64, gx, gy, gz, dt float64) [4]float64 {
+	var dq0, dq1, dq2, dq3 float64
+
+	omegaSq := gx*gx + gy*gy + gz*gz
+	if omegaSq > 1e-12 {
+		omega := math.Sqrt(omegaSq)
+		halfTheta := omega * dt * 0.5
+		s := math.Sin(halfTheta) / omega
+		dq0 = math.Cos(halfTheta)
+		dq1 = gx * s
+		dq2 = gy * s
+		dq3 = gz * s
+	} else {
+		dq0 = 1.0
+		dq1 = gx * dt * 0.5
+		dq2 = gy * dt * 0.5
+		dq3 = gz * dt * 0.5
+	}
+
+	q0, q1, q2, q3 := q[0], q[1], q[2], q[3]
+	r0 := q0*dq0 - q1*dq1 - q2*dq2 - q3*dq3
+	r1 := q0*dq1 + q1*dq0 + q2*dq3 - q3*dq2
+	r2 := q0*dq2 - q1*dq3 + q2*dq0 + q3*dq1
+	r3 := q0*dq3 + q1*dq2 - q2*dq1 + q3*dq0
+
+	recipNorm := invSqrt(r0*r0 + r1*r1 + r2*r2 + r3*r3)
+	return [4]float64{r0 * recipNorm, r1 * recipNorm, r2 * recipNorm, r3 * recipNorm}
+}
+
+// magDeviation returns how far a magnetometer reading has drifted from its
+// learned calm-interval reference, both in total field strength (normDeviation,
+// a fraction of refNorm) and in dip angle (inclDeviation, the angle between
+// (bx, bz) and (refBx, refBz) normalised so that a 90 degree change equals 1).
+// bx/bz are the reference field's horizontal/vertical components resolved via
+// the current attitude estimate, as computed by the Mahony/Madgwick magnetic
+// feedback terms; comparing them catches a constant-norm, rotated-dip
+// disturbance that a norm-only check would miss.
+func magDeviation(norm, refNorm, bx, bz, refBx, refBz float64) (normDeviation, inclDeviation float64) {
+	normDeviation = math.Abs(norm-refNorm) / refNorm
+
+	refHoriz := invSqrt(refBx*refBx + refBz*refBz)
+	curHoriz := invSqrt(bx*bx + bz*bz)
+	if math.IsInf(refHoriz, 1) || math.IsInf(curHoriz, 1) {
+		return normDeviation, 0
+	}
+
+	cosDelta := (bx*refBx + bz*refBz) * refHoriz * curHoriz
+	switch {
+	case cosDelta > 1:
+		cosDelta = 1
+	case cosDelta < -1:
+		cosDelta = -1
+	}
+	inclDeviation = math.Acos(cosDelta) / (math.Pi / 2)
+	return normDeviation, inclDeviation
+}
@@ -0,0 +1,129 @@
+package ahrs
+
+import "math"
+
+// EulerZYX converts a quaternion to roll, pitch and yaw (in radians) using
+// the ZYX (yaw-pitch-roll) Euler sequence. Near +-90 degrees of pitch it
+// switches to a singularity-safe formulation that folds the now-coupled
+// roll and yaw into yaw alone.
+func EulerZYX(q [4]float64) (roll, pitch, yaw float64) {
+	q0, q1, q2, q3 := q[0], q[1], q[2], q[3]
+
+	sinPitch := 2.0 * (q0*q2 - q3*q1)
+	switch {
+	case sinPitch > 1.0:
+		sinPitch = 1.0
+	case sinPitch < -1.0:
+		sinPitch = -1.0
+	}
+	pitch = math.Asin(sinPitch)
+
+	const gimbalLock = 89.0 * math.Pi / 180.0
+	if math.Abs(pitch) > gimbalLock {
+		if pitch > 0 {
+			return 0, pitch, -2.0 * math.Atan2(q1, q0)
+		}
+		return 0, pitch, 2.0 * math.Atan2(q1, q0)
+	}
+
+	roll = math.Atan2(2.0*(q0*q1+q2*q3), 1.0-2.0*(q1*q1+q2*q2))
+	yaw = math.Atan2(2.0*(q0*q3+q1*q2), 1.0-2.0*(q2*q2+q3*q3))
+	return roll, pitch, yaw
+}
+
+// RotationMatrix returns the row-major direction cosine matrix equivalent to
+// q, such that v_world = R . v_body.
+func RotationMatrix(q [4]float64) [9]float64 {
+	q0, q1, q2, q3 := q[0], q[1], q[2], q[3]
+
+	return [9]float64{
+		1 - 2*(q2*q2+q3*q3), 2 * (q1*q2 - q0*q3), 2 * (q1*q3 + q0*q2),
+		2 * (q1*q2 + q0*q3), 1 - 2*(q1*q1+q3*q3), 2 * (q2*q3 - q0*q1),
+		2 * (q1*q3 - q0*q2), 2 * (q2*q3 + q0*q1), 1 - 2*(q1*q1+q2*q2),
+	}
+}
+
+// QuaternionMultiply returns the Hamilton product a (X) b.
+func QuaternionMultiply(a, b [4]float64) [4]float64 {
+	return [4]float64{
+		a[0]*b[0] - a[1]*b[1] - a[2]*b[2] - a[3]*b[3],
+		a[0]*b[1] + a[1]*b[0] + a[2]*b[3] - a[3]*b[2],
+		a[0]*b[2] - a[1]*b[3] + a[2]*b[0] + a[3]*b[1],
+		a[0]*b[3] + a[1]*b[2] - a[2]*b[1] + a[3]*b[0],
+	}
+}
+
+// QuaternionConjugate returns the conjugate of q.
+func QuaternionConjugate(q [4]float64) [4]float64 {
+	return [4]float64{q[0], -q[1], -q[2], -q[3]}
+}
+
+func cross(ax, ay, az, bx, by, bz float64) (cx, cy, cz float64) {
+	return ay*bz - az*by, az*bx - ax*bz, ax*by - ay*bx
+}
+
+// triadQuaternion implements TRIAD initialisation: it builds the body-to-NED
+// rotation from the measured down (accelerometer) and north/east (derived
+// from the magnetometer) directions, then converts it to a quaternion via
+// Shepperd's method.
+func triadQuaternion(ax, ay, az, mx, my, mz float64) [4]float64 {
+	recipNorm := invSqrt(ax*ax + ay*ay + az*az)
+	dx, dy, dz := ax*recipNorm, ay*recipNorm, az*recipNorm
+
+	ex, ey, ez := cross(dx, dy, dz, mx, my, mz)
+	recipNorm = invSqrt(ex*ex + ey*ey + ez*ez)
+	ex, ey, ez = ex*recipNorm, ey*recipNorm, ez*recipNorm
+
+	nx, ny, nz := cross(ex, ey, ez, dx, dy, dz)
+
+	return shepperdQuaternion([9]float64{
+		nx, ny, nz,
+		ex, ey, ez,
+		dx, dy, dz,
+	})
+}
+
+// shepperdQuaternion converts a row-major rotation matrix to a quaternion
+// using Shepperd's method, picking whichever of the four candidate
+// formulations avoids dividing by a near-zero term.
+func shepperdQuaternion(r [9]float64) [4]float64 {
+	r00, r01, r02 := r[0], r[1], r[2]
+	r10, r11, r12 := r[3], r[4], r[5]
+	r20, r21, r22 := r[6], r[7], r[8]
+
+	c0 := 1 + r00 + r11 + r22
+	c1 := 1 + r00 - r11 - r22
+	c2 := 1 - r00 + r11 - r22
+	c3 := 1 - r00 - r11 + r22
+
+	var q0, q1, q2, q3 float64
+	switch {
+	case c0 >= c1 && c0 >= c2 && c0 >= c3:
+		s := math.Sqrt(c0) * 2
+		q0 = 0.25 * s
+		q1 = (r21 - r12) / s
+		q2 = (r02 - r20) / s
+		q3 = (r10 - r01) / s
+	case c1 >= c2 && c1 >= c3:
+		s := math.Sqrt(c1) * 2
+		q0 = (r21 - r12) / s
+		q1 = 0.25 * s
+		q2 = (r01 + r10) / s
+		q3 = (r02 + r20) / s
+	case c2 >= c3:
+		s := math.Sqrt(c2) * 2
+		q0 = (r02 - r20) / s
+		q1 = (r01 + r10) / s
+		q2 = 0.25 * s
+		q3 = (r12 + r21) / s
+	default:
+		s := math.Sqrt(c3) * 2
+		q0 = (r10 - r01) / s
+		q1 = (r02 + r20) / s
+		q2 = (r12 + r21) / s
+		q3 = 0.25 * s
+	}
+
+	recipNorm := invSqrt(q0*q0 + q1*q1 + q2*q2 + q3*q3)
+	return [4]float64{q0 * recipNorm, q1 * recipNorm, q2 * recipNorm, q3 * recipNorm}
+}
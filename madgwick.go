@@ -0,0 +1,348 @@
+package ahrs
+
+import "math"
+
+const MadgwickDefaultBeta = 0.1
+
+// Madgwick instance
+type Madgwick struct {
+	beta float64
+
+	gravity                    float64
+	accelRejectFrac            float64
+	magRejectFrac              float64
+	magRefNorm                 float64
+	magRefBx, magRefBz         float64
+	accelRejected, magRejected bool
+
+	SampleFreq  float64
+	Quaternions [4]float64
+}
+
+// NewMadgwick initiates a Madgwick struct
+func NewMadgwick(beta, sampleFreq float64) Madgwick {
+	return Madgwick{
+		beta: beta,
+
+		gravity: StandardGravity,
+
+		SampleFreq:  sampleFreq,
+		Quaternions: [4]float64{1, 0, 0, 0},
+	}
+}
+
+// NewDefaultMadgwick initiates a Madgwick struct with default beta
+func NewDefaultMadgwick(sampleFreq float64) Madgwick {
+	return Madgwick{
+		beta: MadgwickDefaultBeta,
+
+		gravity: StandardGravity,
+
+		SampleFreq:  sampleFreq,
+		Quaternions: [4]float64{1, 0, 0, 0},
+	}
+}
+
+// SetGravity sets the reference accelerometer magnitude (in the caller's
+// accelerometer units) used by the accelerometer rejection threshold.
+func (m *Madgwick) SetGravity(g float64) {
+	m.gravity = g
+}
+
+// SetAccelReject sets the fractional deviation of the accelerometer norm from
+// SetGravity's reference beyond which the accelerometer correction is
+// rejected as linear acceleration. A value of 0 (the default) disables
+// rejection.
+func (m *Madgwick) SetAccelReject(frac float64) {
+	m.accelRejectFrac = frac
+}
+
+// SetMagReject sets the fractional deviation of the magnetometer norm, and
+// the (identically scaled) deviation of its dip angle, from their learned
+// reference beyond which the magnetometer correction is rejected as a local
+// disturbance. A value of 0 (the default) disables rejection.
+func (m *Madgwick) SetMagReject(frac float64) {
+	m.magRejectFrac = frac
+}
+
+// AccelRejected reports whether the most recent update rejected the
+// accelerometer correction.
+func (m *Madgwick) AccelRejected() bool {
+	return m.accelRejected
+}
+
+// MagRejected reports whether the most recent update rejected the
+// magnetometer correction.
+func (m *Madgwick) MagRejected() bool {
+	return m.magRejected
+}
+
+// madgwickGravityGradient computes the (unnormalised) gradient descent step
+// of the gravity-alignment objective function alone, shared by the IMU-only
+// update and the 9D update's accelerometer term.
+func madgwickGravityGradient(q0, q1, q2, q3, ax, ay, az float64) (s0, s1, s2, s3 float64) {
+	_2q0 := 2.0 * q0
+	_2q1 := 2.0 * q1
+	_2q2 := 2.0 * q2
+	_2q3 := 2.0 * q3
+	_4q0 := 4.0 * q0
+	_4q1 := 4.0 * q1
+	_4q2 := 4.0 * q2
+	_8q1 := 8.0 * q1
+	_8q2 := 8.0 * q2
+	q0q0 := q0 * q0
+	q1q1 := q1 * q1
+	q2q2 := q2 * q2
+	q3q3 := q3 * q3
+
+	s0 = _4q0*q2q2 + _2q2*ax + _4q0*q1q1 - _2q1*ay
+	s1 = _4q1*q3q3 - _2q3*ax + 4.0*q0q0*q1 - _2q0*ay - _4q1 + _8q1*q1q1 + _8q1*q2q2 + _4q1*az
+	s2 = 4.0*q0q0*q2 + _2q0*ax + _4q2*q3q3 - _2q3*ay - _4q2 + _8q2*q1q1 + _8q2*q2q2 + _4q2*az
+	s3 = 4.0*q1q1*q3 - _2q1*ax + 4.0*q2q2*q3 - _2q2*ay
+	return
+}
+
+// Update9D updates position using 9D, returning quaternions
+func (m *Madgwick) Update9D(gx, gy, gz, ax, ay, az, mx, my, mz float64) [4]float64 {
+	return m.Update9DDt(gx, gy, gz, ax, ay, az, mx, my, mz, 1.0/m.SampleFreq)
+}
+
+// Update9DDt updates position using 9D and an explicit timestep in seconds, returning quaternions
+func (m *Madgwick) Update9DDt(gx, gy, gz, ax, ay, az, mx, my, mz, dt float64) [4]float64 {
+	var recipNorm float64
+	var s0, s1, s2, s3 float64
+	var qDot1, qDot2, qDot3, qDot4 float64
+	var hx, hy float64
+	var _2q0mx, _2q0my, _2q0mz, _2q1mx, _2bx, _2bz, _4bx, _4bz float64
+	var _2q0, _2q1, _2q2, _2q3, _2q0q2, _2q2q3 float64
+	var q0q0, q0q1, q0q2, q0q3, q1q1, q1q2, q1q3, q2q2, q2q3, q3q3 float64
+
+	q0 := m.Quaternions[0]
+	q1 := m.Quaternions[1]
+	q2 := m.Quaternions[2]
+	q3 := m.Quaternions[3]
+	beta := m.beta
+
+	// Use IMU algorithm if magnetometer measurement invalid
+	if mx == 0.0 && my == 0.0 && mz == 0.0 {
+		return m.Update6DDt(gx, gy, gz, ax, ay, az, dt)
+	}
+
+	// Rate of change of quaternion from gyroscope
+	qDot1 = 0.5 * (-q1*gx - q2*gy - q3*gz)
+	qDot2 = 0.5 * (q0*gx + q2*gz - q3*gy)
+	qDot3 = 0.5 * (q0*gy - q1*gz + q3*gx)
+	qDot4 = 0.5 * (q0*gz + q1*gy - q2*gx)
+
+	m.accelRejected = false
+	m.magRejected = false
+
+	// Compute feedback only if accelerometer measurement valid (avoids NaN in accelerometer normalisation)
+	if !(ax == 0.0 && ay == 0.0 && az == 0.0) {
+		accelNorm := 1.0 / invSqrt(ax*ax+ay*ay+az*az)
+		useAccel := !(m.accelRejectFrac > 0 && math.Abs(accelNorm-m.gravity)/m.gravity > m.accelRejectFrac)
+		m.accelRejected = !useAccel
+
+		// Normalise accelerometer measurement
+		recipNorm = invSqrt(ax*ax + ay*ay + az*az)
+		ax *= recipNorm
+		ay *= recipNorm
+		az *= recipNorm
+
+		// Normalise magnetometer measurement
+		magNorm := 1.0 / invSqrt(mx*mx+my*my+mz*mz)
+		recipNorm = invSqrt(mx*mx + my*my + mz*mz)
+		mx *= recipNorm
+		my *= recipNorm
+		mz *= recipNorm
+
+		// Auxiliary variables to avoid repeated arithmetic
+		_2q0mx = 2.0 * q0 * mx
+		_2q0my = 2.0 * q0 * my
+		_2q0mz = 2.0 * q0 * mz
+		_2q1mx = 2.0 * q1 * mx
+		_2q0 = 2.0 * q0
+		_2q1 = 2.0 * q1
+		_2q2 = 2.0 * q2
+		_2q3 = 2.0 * q3
+		_2q0q2 = 2.0 * q0 * q2
+		_2q2q3 = 2.0 * q2 * q3
+		q0q0 = q0 * q0
+		q0q1 = q0 * q1
+		q0q2 = q0 * q2
+		q0q3 = q0 * q3
+		q1q1 = q1 * q1
+		q1q2 = q1 * q2
+		q1q3 = q1 * q3
+		q2q2 = q2 * q2
+		q2q3 = q2 * q3
+		q3q3 = q3 * q3
+
+		// Reference direction of Earth's magnetic field
+		hx = mx*q0q0 - _2q0my*q3 + _2q0mz*q2 + mx*q1q1 + _2q1*my*q2 + _2q1*mz*q3 - mx*q2q2 - mx*q3q3
+		hy = _2q0mx*q3 + my*q0q0 - _2q0mz*q1 + _2q1mx*q2 - my*q1q1 + my*q2q2 + _2q2*mz*q3 - my*q3q3
+		_2bx = math.Sqrt(hx*hx + hy*hy)
+		_2bz = -_2q0mx*q2 + _2q0my*q1 + mz*q0q0 + _2q1mx*q3 - mz*q1q1 + _2q2*my*q3 - mz*q2q2 + mz*q3q3
+		_4bx = 2.0 * _2bx
+		_4bz = 2.0 * _2bz
+
+		if m.magRefNorm == 0 {
+			m.magRefNorm = magNorm
+			m.magRefBx = _2bx
+			m.magRefBz = _2bz
+		}
+		normDeviation, inclDeviation := magDeviation(magNorm, m.magRefNorm, _2bx, _2bz, m.magRefBx, m.magRefBz)
+		useMag := !(m.magRejectFrac > 0 && (normDeviation > m.magRejectFrac || inclDeviation > m.magRejectFrac))
+		m.magRejected = !useMag
+		if useMag && m.magRejectFrac > 0 {
+			m.magRefNorm += 0.01 * (magNorm - m.magRefNorm)
+			m.magRefBx += 0.01 * (_2bx - m.magRefBx)
+			m.magRefBz += 0.01 * (_2bz - m.magRefBz)
+		}
+
+		// Gradient decent algorithm corrective step, split into its gravity-alignment
+		// and magnetic-field-alignment terms so either can be dropped when rejected
+		s0 = -_2q2*(2.0*q1q3-_2q0q2-ax) + _2q1*(2.0*q0q1+_2q2q3-ay) - _2bz*q2*(_2bx*(0.5-q2q2-q3q3)+_2bz*(q1q3-q0q2)-mx) + (-_2bx*q3+_2bz*q1)*(_2bx*(q1q2-q0q3)+_2bz*(q0q1+q2q3)-my) + _2bx*q2*(_2bx*(q0q2+q1q3)+_2bz*(0.5-q1q1-q2q2)-mz)
+		s1 = _2q3*(2.0*q1q3-_2q0q2-ax) + _2q0*(2.0*q0q1+_2q2q3-ay) - 4.0*q1*(1-2.0*q1q1-2.0*q2q2-az) + _2bz*q3*(_2bx*(0.5-q2q2-q3q3)+_2bz*(q1q3-q0q2)-mx) + (_2bx*q2+_2bz*q0)*(_2bx*(q1q2-q0q3)+_2bz*(q0q1+q2q3)-my) + (_2bx*q3-_4bz*q1)*(_2bx*(q0q2+q1q3)+_2bz*(0.5-q1q1-q2q2)-mz)
+		s2 = -_2q0*(2.0*q1q3-_2q0q2-ax) + _2q3*(2.0*q0q1+_2q2q3-ay) - 4.0*q2*(1-2.0*q1q1-2.0*q2q2-az) + (-_4bx*q2-_2bz*q0)*(_2bx*(0.5-q2q2-q3q3)+_2bz*(q1q3-q0q2)-mx) + (_2bx*q1+_2bz*q3)*(_2bx*(q1q2-q0q3)+_2bz*(q0q1+q2q3)-my) + (_2bx*q0-_4bz*q2)*(_2bx*(q0q2+q1q3)+_2bz*(0.5-q1q1-q2q2)-mz)
+		s3 = _2q1*(2.0*q1q3-_2q0q2-ax) + _2q2*(2.0*q0q1+_2q2q3-ay) + (-_4bx*q3+_2bz*q1)*(_2bx*(0.5-q2q2-q3q3)+_2bz*(q1q3-q0q2)-mx) + (-_2bx*q0+_2bz*q2)*(_2bx*(q1q2-q0q3)+_2bz*(q0q1+q2q3)-my) + _2bx*q1*(_2bx*(q0q2+q1q3)+_2bz*(0.5-q1q1-q2q2)-mz)
+
+		if !useAccel || !useMag {
+			g0, g1, g2, g3 := madgwickGravityGradient(q0, q1, q2, q3, ax, ay, az)
+			// Field-only term: subtract the gravity gradient out of the combined one
+			f0, f1, f2, f3 := s0-g0, s1-g1, s2-g2, s3-g3
+			s0, s1, s2, s3 = 0, 0, 0, 0
+			if useAccel {
+				s0 += g0
+				s1 += g1
+				s2 += g2
+				s3 += g3
+			}
+			if useMag {
+				s0 += f0
+				s1 += f1
+				s2 += f2
+				s3 += f3
+			}
+		}
+
+		if useAccel || useMag {
+			// Normalise step magnitude
+			recipNorm = invSqrt(s0*s0 + s1*s1 + s2*s2 + s3*s3)
+			s0 *= recipNorm
+			s1 *= recipNorm
+			s2 *= recipNorm
+			s3 *= recipNorm
+
+			// Apply feedback step
+			qDot1 -= beta * s0
+			qDot2 -= beta * s1
+			qDot3 -= beta * s2
+			qDot4 -= beta * s3
+		}
+	}
+
+	// Integrate rate of change of quaternion to yield quaternion
+	q0 += qDot1 * dt
+	q1 += qDot2 * dt
+	q2 += qDot3 * dt
+	q3 += qDot4 * dt
+
+	// Normalise quaternion
+	recipNorm = invSqrt(q0*q0 + q1*q1 + q2*q2 + q3*q3)
+	m.Quaternions[0] = q0 * recipNorm
+	m.Quaternions[1] = q1 * recipNorm
+	m.Quaternions[2] = q2 * recipNorm
+	m.Quaternions[3] = q3 * recipNorm
+
+	return m.Quaternions
+}
+
+// Update6D updates position using 6D, returning quaternions
+func (m *Madgwick) Update6D(gx, gy, gz, ax, ay, az float64) [4]float64 {
+	return m.Update6DDt(gx, gy, gz, ax, ay, az, 1.0/m.SampleFreq)
+}
+
+// Update6DDt updates position using 6D and an explicit timestep in seconds, returning quaternions
+func (m *Madgwick) Update6DDt(gx, gy, gz, ax, ay, az, dt float64) [4]float64 {
+	var recipNorm float64
+	var s0, s1, s2, s3 float64
+	var qDot1, qDot2, qDot3, qDot4 float64
+
+	q0 := m.Quaternions[0]
+	q1 := m.Quaternions[1]
+	q2 := m.Quaternions[2]
+	q3 := m.Quaternions[3]
+	beta := m.beta
+
+	m.accelRejected = false
+	m.magRejected = false
+
+	// Rate of change of quaternion from gyroscope
+	qDot1 = 0.5 * (-q1*gx - q2*gy - q3*gz)
+	qDot2 = 0.5 * (q0*gx + q2*gz - q3*gy)
+	qDot3 = 0.5 * (q0*gy - q1*gz + q3*gx)
+	qDot4 = 0.5 * (q0*gz + q1*gy - q2*gx)
+
+	// Compute feedback only if accelerometer measurement valid (avoids NaN in accelerometer normalisation)
+	if !(ax == 0.0 && ay == 0.0 && az == 0.0) {
+		accelNorm := 1.0 / invSqrt(ax*ax+ay*ay+az*az)
+		if m.accelRejectFrac > 0 && math.Abs(accelNorm-m.gravity)/m.gravity > m.accelRejectFrac {
+			m.accelRejected = true
+		} else {
+			// Normalise accelerometer measurement
+			recipNorm = invSqrt(ax*ax + ay*ay + az*az)
+			ax *= recipNorm
+			ay *= recipNorm
+			az *= recipNorm
+
+			// Gradient decent algorithm corrective step
+			s0, s1, s2, s3 = madgwickGravityGradient(q0, q1, q2, q3, ax, ay, az)
+
+			// Normalise step magnitude
+			recipNorm = invSqrt(s0*s0 + s1*s1 + s2*s2 + s3*s3)
+			s0 *= recipNorm
+			s1 *= recipNorm
+			s2 *= recipNorm
+			s3 *= recipNorm
+
+			// Apply feedback step
+			qDot1 -= beta * s0
+			qDot2 -= beta * s1
+			qDot3 -= beta * s2
+			qDot4 -= beta * s3
+		}
+	}
+
+	// Integrate rate of change of quaternion to yield quaternion
+	q0 += qDot1 * dt
+	q1 += qDot2 * dt
+	q2 += qDot3 * dt
+	q3 += qDot4 * dt
+
+	// Normalise quaternion
+	recipNorm = invSqrt(q0*q0 + q1*q1 + q2*q2 + q3*q3)
+	m.Quaternions[0] = q0 * recipNorm
+	m.Quaternions[1] = q1 * recipNorm
+	m.Quaternions[2] = q2 * recipNorm
+	m.Quaternions[3] = q3 * recipNorm
+
+	return m.Quaternions
+}
+
+// PredictGyro advances the stored quaternion using only the gyroscope,
+// integrating the exact axis-angle exponential map instead of the
+// first-order Euler step used by Update6D/Update9D. This is useful for
+// running the gyro at a higher rate than the accelerometer/magnetometer
+// corrections.
+func (m *Madgwick) PredictGyro(gx, gy, gz, dt float64) [4]float64 {
+	m.Quaternions = quaternionExpIntegrate(m.Quaternions, gx, gy, gz, dt)
+	return m.Quaternions
+}
+
+// InitFromAccelMag sets the stored quaternion to the attitude estimated from
+// a single accelerometer/magnetometer reading via TRIAD, instead of letting
+// it converge from the identity quaternion over successive updates.
+func (m *Madgwick) InitFromAccelMag(ax, ay, az, mx, my, mz float64) {
+	m.Quaternions = triadQuaternion(ax, ay, az, mx, my, mz)
+}
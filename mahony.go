@@ -12,6 +12,13 @@ type Mahony struct {
 	twoKp, twoKi                          float64
 	integralFBx, integralFBy, integralFBz float64
 
+	gravity                    float64
+	accelRejectFrac            float64
+	magRejectFrac              float64
+	magRefNorm                 float64
+	magRefBx, magRefBz         float64
+	accelRejected, magRejected bool
+
 	SampleFreq  float64
 	Quaternions [4]float64
 }
@@ -22,6 +29,8 @@ func NewMahony(kp, ki, sampleFreq float64) Mahony {
 		twoKp: 2 * kp,
 		twoKi: 2 * ki,
 
+		gravity: StandardGravity,
+
 		SampleFreq:  sampleFreq,
 		Quaternions: [4]float64{1, 0, 0, 0},
 	}
@@ -33,13 +42,54 @@ func NewDefaultMahony(sampleFreq float64) Mahony {
 		twoKp: 2 * MahonyDefaultKp,
 		twoKi: 2 * MahonyDefaultKi,
 
+		gravity: StandardGravity,
+
 		SampleFreq:  sampleFreq,
 		Quaternions: [4]float64{1, 0, 0, 0},
 	}
 }
 
+// SetGravity sets the reference accelerometer magnitude (in the caller's
+// accelerometer units) used by the accelerometer rejection threshold.
+func (m *Mahony) SetGravity(g float64) {
+	m.gravity = g
+}
+
+// SetAccelReject sets the fractional deviation of the accelerometer norm from
+// SetGravity's reference beyond which the accelerometer correction is
+// rejected as linear acceleration. A value of 0 (the default) disables
+// rejection.
+func (m *Mahony) SetAccelReject(frac float64) {
+	m.accelRejectFrac = frac
+}
+
+// SetMagReject sets the fractional deviation of the magnetometer norm, and
+// the (identically scaled) deviation of its dip angle, from their learned
+// reference beyond which the magnetometer correction is rejected as a local
+// disturbance. A value of 0 (the default) disables rejection.
+func (m *Mahony) SetMagReject(frac float64) {
+	m.magRejectFrac = frac
+}
+
+// AccelRejected reports whether the most recent update rejected the
+// accelerometer correction.
+func (m *Mahony) AccelRejected() bool {
+	return m.accelRejected
+}
+
+// MagRejected reports whether the most recent update rejected the
+// magnetometer correction.
+func (m *Mahony) MagRejected() bool {
+	return m.magRejected
+}
+
 // Update9D updates position using 9D, returning quaternions
 func (m *Mahony) Update9D(gx, gy, gz, ax, ay, az, mx, my, mz float64) [4]float64 {
+	return m.Update9DDt(gx, gy, gz, ax, ay, az, mx, my, mz, 1.0/m.SampleFreq)
+}
+
+// Update9DDt updates position using 9D and an explicit timestep in seconds, returning quaternions
+func (m *Mahony) Update9DDt(gx, gy, gz, ax, ay, az, mx, my, mz, dt float64) [4]float64 {
 	var recipNorm float64
 	var q0q0, q0q1, q0q2, q0q3, q1q1, q1q2, q1q3, q2q2, q2q3, q3q3 float64
 	var hx, hy, bx, bz float64
@@ -56,10 +106,16 @@ func (m *Mahony) Update9D(gx, gy, gz, ax, ay, az, mx, my, mz float64) [4]float64
 	integralFBz := m.integralFBz
 	twoKi := m.twoKi
 	twoKp := m.twoKp
-	sampleFreq := m.SampleFreq
+
+	m.accelRejected = false
+	m.magRejected = false
 
 	// Compute feedback only if accelerometer measurement valid (avoids NaN in accelerometer normalisation)
 	if !(ax == 0.0 && ay == 0.0 && az == 0.0) {
+		accelNorm := 1.0 / invSqrt(ax*ax+ay*ay+az*az)
+		useAccel := !(m.accelRejectFrac > 0 && math.Abs(accelNorm-m.gravity)/m.gravity > m.accelRejectFrac)
+		m.accelRejected = !useAccel
+
 		// Normalise accelerometer measurement
 		recipNorm = invSqrt(ax*ax + ay*ay + az*az)
 		ax *= recipNorm
@@ -67,6 +123,7 @@ func (m *Mahony) Update9D(gx, gy, gz, ax, ay, az, mx, my, mz float64) [4]float64
 		az *= recipNorm
 
 		// Normalise magnetometer measurement
+		magNorm := 1.0 / invSqrt(mx*mx+my*my+mz*mz)
 		recipNorm = invSqrt(mx*mx + my*my + mz*mz)
 		mx *= recipNorm
 		my *= recipNorm
@@ -90,6 +147,20 @@ func (m *Mahony) Update9D(gx, gy, gz, ax, ay, az, mx, my, mz float64) [4]float64
 		bx = math.Sqrt(hx*hx + hy*hy)
 		bz = 2.0 * (mx*(q1q3-q0q2) + my*(q2q3+q0q1) + mz*(0.5-q1q1-q2q2))
 
+		if m.magRefNorm == 0 {
+			m.magRefNorm = magNorm
+			m.magRefBx = bx
+			m.magRefBz = bz
+		}
+		normDeviation, inclDeviation := magDeviation(magNorm, m.magRefNorm, bx, bz, m.magRefBx, m.magRefBz)
+		useMag := !(m.magRejectFrac > 0 && (normDeviation > m.magRejectFrac || inclDeviation > m.magRejectFrac))
+		m.magRejected = !useMag
+		if useMag && m.magRejectFrac > 0 {
+			m.magRefNorm += 0.01 * (magNorm - m.magRefNorm)
+			m.magRefBx += 0.01 * (bx - m.magRefBx)
+			m.magRefBz += 0.01 * (bz - m.magRefBz)
+		}
+
 		// Estimated direction of gravity and magnetic field
 		halfvx = q1q3 - q0q2
 		halfvy = q0q1 + q2q3
@@ -98,16 +169,25 @@ func (m *Mahony) Update9D(gx, gy, gz, ax, ay, az, mx, my, mz float64) [4]float64
 		halfwy = bx*(q1q2-q0q3) + bz*(q0q1+q2q3)
 		halfwz = bx*(q0q2+q1q3) + bz*(0.5-q1q1-q2q2)
 
-		// Error is sum of cross product between estimated direction and measured direction of field vectors
-		halfex = (ay*halfvz - az*halfvy) + (my*halfwz - mz*halfwy)
-		halfey = (az*halfvx - ax*halfvz) + (mz*halfwx - mx*halfwz)
-		halfez = (ax*halfvy - ay*halfvx) + (mx*halfwy - my*halfwx)
+		// Error is sum of cross product between estimated direction and measured direction of field vectors,
+		// dropping whichever term is currently flagged as disturbed
+		halfex, halfey, halfez = 0, 0, 0
+		if useAccel {
+			halfex += ay*halfvz - az*halfvy
+			halfey += az*halfvx - ax*halfvz
+			halfez += ax*halfvy - ay*halfvx
+		}
+		if useMag {
+			halfex += my*halfwz - mz*halfwy
+			halfey += mz*halfwx - mx*halfwz
+			halfez += mx*halfwy - my*halfwx
+		}
 
 		// Compute and apply integral feedback if enabled
 		if twoKi > 0.0 {
-			integralFBx += twoKi * halfex * (1.0 / sampleFreq) // integral error scaled by Ki
-			integralFBy += twoKi * halfey * (1.0 / sampleFreq)
-			integralFBz += twoKi * halfez * (1.0 / sampleFreq)
+			integralFBx += twoKi * halfex * dt // integral error scaled by Ki
+			integralFBy += twoKi * halfey * dt
+			integralFBz += twoKi * halfez * dt
 			gx += integralFBx // apply integral feedback
 			gy += integralFBy
 			gz += integralFBz
@@ -124,9 +204,9 @@ func (m *Mahony) Update9D(gx, gy, gz, ax, ay, az, mx, my, mz float64) [4]float64
 	}
 
 	// Integrate rate of change of quaternion
-	gx *= (0.5 * (1.0 / sampleFreq)) // pre-multiply common factors
-	gy *= (0.5 * (1.0 / sampleFreq))
-	gz *= (0.5 * (1.0 / sampleFreq))
+	gx *= (0.5 * dt) // pre-multiply common factors
+	gy *= (0.5 * dt)
+	gz *= (0.5 * dt)
 	qa = q0
 	qb = q1
 	qc = q2
@@ -147,6 +227,11 @@ func (m *Mahony) Update9D(gx, gy, gz, ax, ay, az, mx, my, mz float64) [4]float64
 
 // Update6D updates position using 6D, returning quaternions
 func (m *Mahony) Update6D(gx, gy, gz, ax, ay, az float64) [4]float64 {
+	return m.Update6DDt(gx, gy, gz, ax, ay, az, 1.0/m.SampleFreq)
+}
+
+// Update6DDt updates position using 6D and an explicit timestep in seconds, returning quaternions
+func (m *Mahony) Update6DDt(gx, gy, gz, ax, ay, az, dt float64) [4]float64 {
 	var recipNorm float64
 	var halfvx, halfvy, halfvz float64
 	var halfex, halfey, halfez float64
@@ -161,10 +246,15 @@ func (m *Mahony) Update6D(gx, gy, gz, ax, ay, az float64) [4]float64 {
 	integralFBz := m.integralFBz
 	twoKi := m.twoKi
 	twoKp := m.twoKp
-	sampleFreq := m.SampleFreq
+
+	m.accelRejected = false
+	m.magRejected = false
 
 	// Compute feedback only if accelerometer measurement valid (avoids NaN in accelerometer normalisation)
 	if !(ax == 0.0 && ay == 0.0 && az == 0.0) {
+		accelNorm := 1.0 / invSqrt(ax*ax+ay*ay+az*az)
+		useAccel := !(m.accelRejectFrac > 0 && math.Abs(accelNorm-m.gravity)/m.gravity > m.accelRejectFrac)
+		m.accelRejected = !useAccel
 
 		// Normalise accelerometer measurement
 		recipNorm = invSqrt(ax*ax + ay*ay + az*az)
@@ -178,15 +268,18 @@ func (m *Mahony) Update6D(gx, gy, gz, ax, ay, az float64) [4]float64 {
 		halfvz = q0*q0 - 0.5 + q3*q3
 
 		// Error is sum of cross product between estimated and measured direction of gravity
-		halfex = (ay*halfvz - az*halfvy)
-		halfey = (az*halfvx - ax*halfvz)
-		halfez = (ax*halfvy - ay*halfvx)
+		halfex, halfey, halfez = 0, 0, 0
+		if useAccel {
+			halfex = ay*halfvz - az*halfvy
+			halfey = az*halfvx - ax*halfvz
+			halfez = ax*halfvy - ay*halfvx
+		}
 
 		// Compute and apply integral feedback if enabled
 		if twoKi > 0.0 {
-			integralFBx += twoKi * halfex * (1.0 / sampleFreq) // integral error scaled by Ki
-			integralFBy += twoKi * halfey * (1.0 / sampleFreq)
-			integralFBz += twoKi * halfez * (1.0 / sampleFreq)
+			integralFBx += twoKi * halfex * dt // integral error scaled by Ki
+			integralFBy += twoKi * halfey * dt
+			integralFBz += twoKi * halfez * dt
 			gx += integralFBx // apply integral feedback
 			gy += integralFBy
 			gz += integralFBz
@@ -203,9 +296,9 @@ func (m *Mahony) Update6D(gx, gy, gz, ax, ay, az float64) [4]float64 {
 	}
 
 	// Integrate rate of change of quaternion
-	gx *= (0.5 * (1.0 / sampleFreq)) // pre-multiply common factors
-	gy *= (0.5 * (1.0 / sampleFreq))
-	gz *= (0.5 * (1.0 / sampleFreq))
+	gx *= (0.5 * dt) // pre-multiply common factors
+	gy *= (0.5 * dt)
+	gz *= (0.5 * dt)
 	qa = q0
 	qb = q1
 	qc = q2
@@ -223,3 +316,20 @@ func (m *Mahony) Update6D(gx, gy, gz, ax, ay, az float64) [4]float64 {
 
 	return m.Quaternions
 }
+
+// PredictGyro advances the stored quaternion using only the gyroscope,
+// integrating the exact axis-angle exponential map instead of the
+// first-order Euler step used by Update6D/Update9D. This is useful for
+// running the gyro at a higher rate than the accelerometer/magnetometer
+// corrections.
+func (m *Mahony) PredictGyro(gx, gy, gz, dt float64) [4]float64 {
+	m.Quaternions = quaternionExpIntegrate(m.Quaternions, gx, gy, gz, dt)
+	return m.Quaternions
+}
+
+// InitFromAccelMag sets the stored quaternion to the attitude estimated from
+// a single accelerometer/magnetometer reading via TRIAD, instead of letting
+// it converge from the identity quaternion over successive updates.
+func (m *Mahony) InitFromAccelMag(ax, ay, az, mx, my, mz float64) {
+	m.Quaternions = triadQuaternion(ax, ay, az, mx, my, mz)
+}
@@ -0,0 +1,156 @@
+package ahrs
+
+import "math"
+
+const (
+	SO3CompDefaultKp = 1.0
+	SO3CompDefaultKi = 0.3
+)
+
+// SO3Comp instance implements the passive nonlinear complementary filter on
+// SO(3) popularised by PX4's attitude_estimator_so3_comp, with online gyro
+// bias estimation.
+type SO3Comp struct {
+	kp, ki float64
+
+	biasX, biasY, biasZ float64
+
+	SampleFreq  float64
+	Quaternions [4]float64
+}
+
+// NewSO3Comp initiates a SO3Comp struct
+func NewSO3Comp(kp, ki, sampleFreq float64) SO3Comp {
+	return SO3Comp{
+		kp: kp,
+		ki: ki,
+
+		SampleFreq:  sampleFreq,
+		Quaternions: [4]float64{1, 0, 0, 0},
+	}
+}
+
+// Bias returns the current estimated gyro bias vector
+func (f *SO3Comp) Bias() [3]float64 {
+	return [3]float64{f.biasX, f.biasY, f.biasZ}
+}
+
+// Reset clears the estimated attitude and gyro bias
+func (f *SO3Comp) Reset() {
+	f.biasX, f.biasY, f.biasZ = 0, 0, 0
+	f.Quaternions = [4]float64{1, 0, 0, 0}
+}
+
+// Update9D updates position using 9D, returning quaternions
+func (f *SO3Comp) Update9D(gx, gy, gz, ax, ay, az, mx, my, mz float64) [4]float64 {
+	return f.Update9DDt(gx, gy, gz, ax, ay, az, mx, my, mz, 1.0/f.SampleFreq)
+}
+
+// Update9DDt updates position using 9D and an explicit timestep in seconds, returning quaternions
+func (f *SO3Comp) Update9DDt(gx, gy, gz, ax, ay, az, mx, my, mz, dt float64) [4]float64 {
+	var recipNorm float64
+	var q0q0, q0q1, q0q2, q0q3, q1q1, q1q2, q1q3, q2q2, q2q3, q3q3 float64
+	var hx, hy, bx, bz float64
+	var vx, vy, vz, wx, wy, wz float64
+	var ex, ey, ez float64
+
+	q0 := f.Quaternions[0]
+	q1 := f.Quaternions[1]
+	q2 := f.Quaternions[2]
+	q3 := f.Quaternions[3]
+	kp := f.kp
+	ki := f.ki
+
+	haveMag := !(mx == 0.0 && my == 0.0 && mz == 0.0)
+
+	// Compute feedback only if accelerometer measurement valid (avoids NaN in accelerometer normalisation)
+	if !(ax == 0.0 && ay == 0.0 && az == 0.0) {
+		// Normalise accelerometer measurement
+		recipNorm = invSqrt(ax*ax + ay*ay + az*az)
+		ax *= recipNorm
+		ay *= recipNorm
+		az *= recipNorm
+
+		// Auxiliary variables to avoid repeated arithmetic
+		q0q0 = q0 * q0
+		q0q1 = q0 * q1
+		q0q2 = q0 * q2
+		q0q3 = q0 * q3
+		q1q1 = q1 * q1
+		q1q2 = q1 * q2
+		q1q3 = q1 * q3
+		q2q2 = q2 * q2
+		q2q3 = q2 * q3
+		q3q3 = q3 * q3
+
+		// Estimated body-frame direction of gravity, v = R^T . [0,0,1]
+		vx = 2.0 * (q1q3 - q0q2)
+		vy = 2.0 * (q0q1 + q2q3)
+		vz = q0q0 - q1q1 - q2q2 + q3q3
+
+		// Error is cross product between estimated and measured direction of gravity
+		ex = ay*vz - az*vy
+		ey = az*vx - ax*vz
+		ez = ax*vy - ay*vx
+
+		if haveMag {
+			// Normalise magnetometer measurement
+			recipNorm = invSqrt(mx*mx + my*my + mz*mz)
+			mx *= recipNorm
+			my *= recipNorm
+			mz *= recipNorm
+
+			// Reference direction of Earth's magnetic field
+			hx = 2.0 * (mx*(0.5-q2q2-q3q3) + my*(q1q2-q0q3) + mz*(q1q3+q0q2))
+			hy = 2.0 * (mx*(q1q2+q0q3) + my*(0.5-q1q1-q3q3) + mz*(q2q3-q0q1))
+			bx = math.Sqrt(hx*hx + hy*hy)
+			bz = 2.0 * (mx*(q1q3-q0q2) + my*(q2q3+q0q1) + mz*(0.5-q1q1-q2q2))
+
+			// Estimated body-frame direction of magnetic field, w = R^T . h
+			wx = 2.0 * (bx*(0.5-q2q2-q3q3) + bz*(q1q3-q0q2))
+			wy = 2.0 * (bx*(q1q2-q0q3) + bz*(q0q1+q2q3))
+			wz = 2.0 * (bx*(q0q2+q1q3) + bz*(0.5-q1q1-q2q2))
+
+			// Add the cross product between estimated and measured direction of magnetic field
+			ex += my*wz - mz*wy
+			ey += mz*wx - mx*wz
+			ez += mx*wy - my*wx
+		}
+
+		// Update gyro bias estimate from the integral term
+		f.biasX -= ki * ex * dt
+		f.biasY -= ki * ey * dt
+		f.biasZ -= ki * ez * dt
+
+		// Bias-corrected rate plus proportional feedback
+		gx += -f.biasX + kp*ex
+		gy += -f.biasY + kp*ey
+		gz += -f.biasZ + kp*ez
+	} else {
+		gx -= f.biasX
+		gy -= f.biasY
+		gz -= f.biasZ
+	}
+
+	// Integrate the corrected rate using the exact exponential map
+	f.Quaternions = quaternionExpIntegrate(f.Quaternions, gx, gy, gz, dt)
+
+	return f.Quaternions
+}
+
+// Update6D updates position using 6D, returning quaternions
+func (f *SO3Comp) Update6D(gx, gy, gz, ax, ay, az float64) [4]float64 {
+	return f.Update6DDt(gx, gy, gz, ax, ay, az, 1.0/f.SampleFreq)
+}
+
+// Update6DDt updates position using 6D and an explicit timestep in seconds, returning quaternions
+func (f *SO3Comp) Update6DDt(gx, gy, gz, ax, ay, az, dt float64) [4]float64 {
+	return f.Update9DDt(gx, gy, gz, ax, ay, az, 0, 0, 0, dt)
+}
+
+// PredictGyro advances the stored quaternion using only the gyroscope,
+// bypassing the accelerometer/magnetometer correction and bias update.
+func (f *SO3Comp) PredictGyro(gx, gy, gz, dt float64) [4]float64 {
+	f.Quaternions = quaternionExpIntegrate(f.Quaternions, gx-f.biasX, gy-f.biasY, gz-f.biasZ, dt)
+	return f.Quaternions
+}